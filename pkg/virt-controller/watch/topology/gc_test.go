@@ -0,0 +1,130 @@
+package topology
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"go.uber.org/mock/gomock"
+
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+type forgetfulHinter struct {
+	fakeHinter
+	forgotten []string
+}
+
+func (f *forgetfulHinter) ForgetNode(nodeName string) {
+	f.forgotten = append(f.forgotten, nodeName)
+}
+
+var _ = Describe("nodeTopologyUpdater.gcStaleLabels", func() {
+	var clientset *fake.Clientset
+	var informer cache.SharedIndexInformer
+	var updater *nodeTopologyUpdater
+
+	staleNode := func(name string) *v1.Node {
+		return &v1.Node{
+			ObjectMeta: v12.ObjectMeta{
+				Name: name,
+				Labels: map[string]string{
+					"scheduling.node.kubevirt.io/tsc-frequency-3000": "true",
+				},
+			},
+		}
+	}
+
+	BeforeEach(func() {
+		ctrl := gomock.NewController(GinkgoT())
+		DeferCleanup(ctrl.Finish)
+
+		clientset = fake.NewSimpleClientset()
+		informer = informers.NewSharedInformerFactory(clientset, 0).Core().V1().Nodes().Informer()
+		updater = &nodeTopologyUpdater{
+			client:       newTestClient(ctrl, clientset),
+			hinter:       &fakeHinter{},
+			nodeInformer: informer,
+			nodeStore:    informer.GetStore(),
+			queue:        workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+			patchMode:    StrategicMerge,
+		}
+	})
+
+	DescribeTable("strips stale TSC frequency labels",
+		func(setup func(), assertions func()) {
+			setup()
+			updater.gcStaleLabels()
+			assertions()
+		},
+		Entry("node loses invariance but keeps its TSC frequency labels", func() {
+			node := staleNode("node01")
+			Expect(informer.GetStore().Add(node)).To(Succeed())
+			_, err := clientset.CoreV1().Nodes().Create(context.TODO(), node, v12.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+		}, func() {
+			updated, err := clientset.CoreV1().Nodes().Get(context.TODO(), "node01", v12.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(updated.Labels).ToNot(HaveKey("scheduling.node.kubevirt.io/tsc-frequency-3000"))
+		}),
+		Entry("node was deleted and no longer appears in the store", func() {
+			// Nothing in the store; gcStaleLabels must be a no-op.
+		}, func() {
+			Expect(clientset.Actions()).To(BeEmpty())
+		}),
+		Entry("node was re-added after losing invariance and keeps no stale labels", func() {
+			node := staleNode("node01")
+			delete(node.Labels, "scheduling.node.kubevirt.io/tsc-frequency-3000")
+			Expect(informer.GetStore().Add(node)).To(Succeed())
+			_, err := clientset.CoreV1().Nodes().Create(context.TODO(), node, v12.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			// Drop the create recorded above so only gcStaleLabels' own
+			// calls are asserted on.
+			clientset.ClearActions()
+		}, func() {
+			Expect(clientset.Actions()).To(BeEmpty())
+		}),
+	)
+
+	It("clears the hinter's cached view of a node on deletion", func() {
+		hinter := &forgetfulHinter{}
+		updater.hinter = hinter
+
+		node := staleNode("node01")
+		Expect(informer.GetStore().Add(node)).To(Succeed())
+
+		// Exercise the actual handler the production reconcile loop
+		// registers, rather than a hand-duplicated copy of it, so this test
+		// fails if the DeleteFunc built by eventHandlerFuncs ever stops
+		// calling ForgetNode.
+		updater.eventHandlerFuncs().DeleteFunc(node)
+
+		Expect(hinter.forgotten).To(ConsistOf("node01"))
+	})
+
+	Context("in ServerSideApply mode", func() {
+		BeforeEach(func() {
+			updater.patchMode = ServerSideApply
+		})
+
+		It("prunes stale TSC frequency labels via Server-Side Apply", func() {
+			node := staleNode("node01")
+			Expect(informer.GetStore().Add(node)).To(Succeed())
+			_, err := clientset.CoreV1().Nodes().Create(context.TODO(), node, v12.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			updater.gcStaleLabels()
+
+			updated, err := clientset.CoreV1().Nodes().Get(context.TODO(), "node01", v12.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(updated.Labels).ToNot(HaveKey("scheduling.node.kubevirt.io/tsc-frequency-3000"))
+		})
+	})
+})
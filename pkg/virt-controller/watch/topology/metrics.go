@@ -0,0 +1,37 @@
+package topology
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	nodeLabelsUpdatedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kubevirt_tsc_node_labels_updated_total",
+		Help: "The number of times a node's TSC frequency labels were successfully updated.",
+	}, []string{"node"})
+
+	nodeLabelsSkippedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kubevirt_tsc_node_labels_skipped_total",
+		Help: "The number of reconciliations that found a node's TSC frequency labels already up to date.",
+	}, []string{"node"})
+
+	nodeLabelsErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kubevirt_tsc_node_labels_errors_total",
+		Help: "The number of errors encountered while reconciling a node's TSC frequency labels.",
+	}, []string{"node", "reason"})
+
+	requiredFrequenciesCount = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kubevirt_tsc_required_frequencies_count",
+		Help: "The number of distinct TSC frequencies currently required by workloads on the cluster.",
+	})
+)
+
+// RegisterMetrics registers the TSC node topology collectors with the given
+// registerer. Callers are expected to pass the virt-controller metrics
+// registry so the collectors are exposed on the shared /metrics endpoint.
+func RegisterMetrics(registerer prometheus.Registerer) {
+	registerer.MustRegister(
+		nodeLabelsUpdatedTotal,
+		nodeLabelsSkippedTotal,
+		nodeLabelsErrorsTotal,
+		requiredFrequenciesCount,
+	)
+}
@@ -0,0 +1,24 @@
+package topology
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RegisterMetrics", func() {
+	It("registers the TSC node topology collectors exactly once", func() {
+		registry := prometheus.NewRegistry()
+		Expect(func() { RegisterMetrics(registry) }).ToNot(Panic())
+
+		families, err := registry.Gather()
+		Expect(err).ToNot(HaveOccurred())
+
+		var names []string
+		for _, family := range families {
+			names = append(names, family.GetName())
+		}
+		Expect(names).To(ContainElement("kubevirt_tsc_required_frequencies_count"))
+	})
+})
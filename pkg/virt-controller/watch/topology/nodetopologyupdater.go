@@ -5,66 +5,343 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"strings"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 
 	"kubevirt.io/client-go/kubecli"
 
 	"kubevirt.io/client-go/log"
 )
 
+const (
+	// defaultWorkerCount is the number of worker goroutines draining the
+	// reconciliation workqueue.
+	defaultWorkerCount = 3
+
+	// tscFrequencyLabelPrefix is the prefix of the TSC frequency labels this
+	// controller owns. Only labels under this prefix are ever included in a
+	// Server-Side Apply patch.
+	tscFrequencyLabelPrefix = "scheduling.node.kubevirt.io/tsc-frequency-"
+
+	// fieldManager identifies this controller as the owner of the TSC
+	// frequency labels it applies via Server-Side Apply.
+	fieldManager = "kubevirt-node-topology-updater"
+)
+
+// PatchMode selects how nodeTopologyUpdater writes TSC frequency label
+// changes back to the node object.
+type PatchMode string
+
+const (
+	// StrategicMerge sends a strategic merge patch computed from the diff
+	// between the cached node and the desired node.
+	StrategicMerge PatchMode = "StrategicMerge"
+	// ServerSideApply makes this controller the declared field owner of the
+	// TSC frequency labels it manages, via the Server-Side Apply API.
+	ServerSideApply PatchMode = "ServerSideApply"
+)
+
 type NodeTopologyUpdater interface {
 	Run(interval time.Duration, stopChan <-chan struct{})
+	// Requeue schedules nodeName for reevaluation, e.g. when the set of
+	// required TSC frequencies changes.
+	Requeue(nodeName string)
+}
+
+// LeaderElectionConfig controls the lease used to guarantee that only a
+// single virt-controller replica reconciles TSC node labels at a time.
+type LeaderElectionConfig struct {
+	LeaseName      string
+	LeaseNamespace string
+	LeaseDuration  time.Duration
+	RenewDeadline  time.Duration
+	RetryPeriod    time.Duration
 }
 
 type nodeTopologyUpdater struct {
-	nodeStore cache.Store
-	hinter    Hinter
-	client    kubecli.KubevirtClient
+	nodeInformer  cache.SharedIndexInformer
+	nodeStore     cache.Store
+	hinter        Hinter
+	client        kubecli.KubevirtClient
+	queue         workqueue.RateLimitingInterface
+	workers       int
+	eventRecorder record.EventRecorder
+	patchMode     PatchMode
+
+	leaderElectionConfig LeaderElectionConfig
+	identity             string
+}
+
+// hinterChangeSubscriber is implemented by Hinter instances that can notify
+// the updater when the cluster-wide required frequency set changes, so that
+// all nodes can be reevaluated without waiting for the full resync.
+type hinterChangeSubscriber interface {
+	AddEventHandler(handler func())
 }
 
-type stats struct {
-	updated int
-	skipped int
-	error   int
+// hinterNodeForgetter is implemented by Hinter instances that cache
+// per-node state. Notifying it of node deletions keeps requiredFrequencies
+// from counting frequencies that were only ever in use on a node that no
+// longer exists.
+type hinterNodeForgetter interface {
+	ForgetNode(nodeName string)
+}
+
+// nodeNameFromObj extracts a node name from an informer event object, which
+// may be a cache.DeletedFinalStateUnknown tombstone on delete.
+func nodeNameFromObj(obj interface{}) (string, bool) {
+	if node, ok := obj.(*v1.Node); ok {
+		return node.Name, true
+	}
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		if node, ok := tombstone.Obj.(*v1.Node); ok {
+			return node.Name, true
+		}
+	}
+	return "", false
 }
 
 func (n *nodeTopologyUpdater) Run(interval time.Duration, stopChan <-chan struct{}) {
-	wait.JitterUntil(func() {
-		requiredFrequencies := n.requiredFrequencies()
-		nodes := FilterNodesFromCache(n.nodeStore.List(),
-			HasInvTSCFrequency,
-		)
-		stats := &stats{}
-		for _, node := range nodes {
-			nodeCopy, err := calculateNodeLabelChanges(node, requiredFrequencies)
-			if err != nil {
-				stats.error++
-				log.DefaultLogger().Object(node).Reason(err).Error("Could not calculate TSC frequencies for node")
-				continue
-			}
-			if !reflect.DeepEqual(node.Labels, nodeCopy.Labels) {
-				if err := patchNode(n.client, node, nodeCopy); err != nil {
-					stats.error++
-					log.DefaultLogger().Object(node).Reason(err).Error("Could not patch TSC frequencies for node")
-					continue
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		<-stopChan
+		cancel()
+	}()
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: v12.ObjectMeta{
+			Name:      n.leaderElectionConfig.LeaseName,
+			Namespace: n.leaderElectionConfig.LeaseNamespace,
+		},
+		Client: n.client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: n.identity,
+		},
+	}
+
+	// LeaderElector.Run (and therefore RunOrDie) returns as soon as this
+	// replica stops holding the lease, not only when ctx is done. Keep
+	// competing for the lease for the life of the process, otherwise a
+	// single lost-leadership event (e.g. a missed renewal) would
+	// permanently stop reconciliation on this replica.
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			ReleaseOnCancel: true,
+			LeaseDuration:   n.leaderElectionConfig.LeaseDuration,
+			RenewDeadline:   n.leaderElectionConfig.RenewDeadline,
+			RetryPeriod:     n.leaderElectionConfig.RetryPeriod,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(loopCtx context.Context) {
+					log.DefaultLogger().Info("Acquired TSC node topology leader lease, starting reconciliation")
+					n.runReconcileLoop(loopCtx, interval)
+				},
+				OnStoppedLeading: func() {
+					log.DefaultLogger().Info("Lost TSC node topology leader lease, stopping reconciliation")
+				},
+			},
+		})
+	}
+}
+
+// runReconcileLoop wires up the informer event handlers and worker pool and
+// blocks, processing queued node keys, until loopCtx is cancelled. This
+// happens as soon as leadership is lost.
+func (n *nodeTopologyUpdater) runReconcileLoop(loopCtx context.Context, interval time.Duration) {
+	defer n.queue.ShutDown()
+
+	handlerRegistration, err := n.nodeInformer.AddEventHandler(n.eventHandlerFuncs())
+	if err != nil {
+		log.DefaultLogger().Reason(err).Error("Could not attach event handler to node informer")
+		return
+	}
+	defer func() {
+		_ = n.nodeInformer.RemoveEventHandler(handlerRegistration)
+	}()
+
+	if subscriber, ok := n.hinter.(hinterChangeSubscriber); ok {
+		subscriber.AddEventHandler(n.enqueueAllNodes)
+	}
+
+	for i := 0; i < n.workers; i++ {
+		go wait.Until(n.runWorker, time.Second, loopCtx.Done())
+	}
+
+	// Full resync as a safety net for missed or coalesced informer events,
+	// paired with a garbage-collection pass for nodes that dropped out of
+	// the TSC-scalable set entirely and would otherwise keep stale labels
+	// forever.
+	wait.Until(func() {
+		n.enqueueAllNodes()
+		n.gcStaleLabels()
+	}, interval, loopCtx.Done())
+}
+
+// eventHandlerFuncs builds the node informer event handlers. Kept as its own
+// method, rather than an inline literal in runReconcileLoop, so tests can
+// exercise the exact same handler the production reconcile loop registers.
+func (n *nodeTopologyUpdater) eventHandlerFuncs() cache.ResourceEventHandlerFuncs {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { n.enqueueNode(obj) },
+		UpdateFunc: func(_, newObj interface{}) { n.enqueueNode(newObj) },
+		DeleteFunc: func(obj interface{}) {
+			n.enqueueNode(obj)
+			if forgetter, ok := n.hinter.(hinterNodeForgetter); ok {
+				if name, ok := nodeNameFromObj(obj); ok {
+					forgetter.ForgetNode(name)
 				}
-				stats.updated++
-			} else {
-				stats.skipped++
 			}
+		},
+	}
+}
+
+func (n *nodeTopologyUpdater) enqueueNode(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		log.DefaultLogger().Reason(err).Error("Could not compute key for node")
+		return
+	}
+	n.queue.Add(key)
+}
+
+func (n *nodeTopologyUpdater) enqueueAllNodes() {
+	for _, node := range n.nodeStore.List() {
+		n.enqueueNode(node)
+	}
+}
+
+// Requeue schedules nodeName for reevaluation on the next worker cycle.
+func (n *nodeTopologyUpdater) Requeue(nodeName string) {
+	n.queue.Add(nodeName)
+}
+
+func (n *nodeTopologyUpdater) runWorker() {
+	for n.processNextWorkItem() {
+	}
+}
+
+func (n *nodeTopologyUpdater) processNextWorkItem() bool {
+	key, shutdown := n.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer n.queue.Done(key)
+
+	if err := n.reconcileNode(key.(string)); err != nil {
+		log.DefaultLogger().Reason(err).Errorf("Could not reconcile TSC frequencies for node %s, retrying", key)
+		n.queue.AddRateLimited(key)
+		return true
+	}
+	n.queue.Forget(key)
+	return true
+}
+
+// reconcileNode brings the node's TSC frequency labels in line with the
+// cluster's currently required frequencies.
+func (n *nodeTopologyUpdater) reconcileNode(key string) error {
+	obj, exists, err := n.nodeStore.GetByKey(key)
+	if err != nil {
+		return fmt.Errorf("could not look up node %s: %v", key, err)
+	}
+	if !exists {
+		// The node was deleted; nothing left to reconcile.
+		return nil
+	}
+	node, ok := obj.(*v1.Node)
+	if !ok {
+		return fmt.Errorf("unexpected object of type %T for key %s", obj, key)
+	}
+	if !HasInvTSCFrequency(node) {
+		return nil
+	}
+
+	requiredFrequencies := n.requiredFrequencies()
+	nodeCopy, added, removed, err := calculateNodeLabelChanges(node, requiredFrequencies)
+	if err != nil {
+		nodeLabelsErrorsTotal.WithLabelValues(node.Name, "calculate_failed").Inc()
+		return fmt.Errorf("could not calculate TSC frequencies for node %s: %v", node.Name, err)
+	}
+	if reflect.DeepEqual(node.Labels, nodeCopy.Labels) {
+		nodeLabelsSkippedTotal.WithLabelValues(node.Name).Inc()
+		return nil
+	}
+	if err := patchNode(n.client, node, nodeCopy, n.patchMode); err != nil {
+		nodeLabelsErrorsTotal.WithLabelValues(node.Name, "patch_failed").Inc()
+		if apierrors.IsConflict(err) {
+			log.DefaultLogger().Object(node).Reason(err).Error("Conflict applying TSC frequency labels with another field manager")
+		}
+		if n.eventRecorder != nil {
+			n.eventRecorder.Eventf(node, v1.EventTypeWarning, "TSCLabelPatchFailed", "Failed to patch TSC frequency labels: %v", err)
+		}
+		return fmt.Errorf("could not patch TSC frequencies for node %s: %v", node.Name, err)
+	}
+	nodeLabelsUpdatedTotal.WithLabelValues(node.Name).Inc()
+	if n.eventRecorder != nil {
+		n.eventRecorder.Eventf(node, v1.EventTypeNormal, "TSCLabelsUpdated", "Updated TSC frequency labels, added: %v, removed: %v", added, removed)
+	}
+	return nil
+}
+
+// gcStaleLabels strips TSC frequency labels from nodes that no longer
+// advertise an invariant TSC. Unlike reconcileNode, it is not gated on
+// HasInvTSCFrequency: that filter is exactly what lets stale labels survive
+// on a node that lost invariance, since reconcileNode returns early for such
+// a node on every future reconciliation.
+func (n *nodeTopologyUpdater) gcStaleLabels() {
+	for _, obj := range n.nodeStore.List() {
+		node, ok := obj.(*v1.Node)
+		if !ok {
+			continue
+		}
+		if HasInvTSCFrequency(node) {
+			continue
+		}
+		staleFrequencies := TSCFrequenciesOnNode(node)
+		if len(staleFrequencies) == 0 {
+			continue
+		}
+
+		staleLabels := ToLabels(staleFrequencies)
+		nodeCopy := node.DeepCopy()
+		for _, label := range staleLabels {
+			delete(nodeCopy.Labels, label)
+		}
+
+		if err := patchNode(n.client, node, nodeCopy, n.patchMode); err != nil {
+			nodeLabelsErrorsTotal.WithLabelValues(node.Name, "gc_failed").Inc()
+			log.DefaultLogger().Object(node).Reason(err).Error("Could not garbage-collect stale TSC frequency labels for node")
+			if n.eventRecorder != nil {
+				n.eventRecorder.Eventf(node, v1.EventTypeWarning, "TSCLabelPatchFailed", "Failed to garbage-collect stale TSC frequency labels: %v", err)
+			}
+			continue
 		}
-		log.DefaultLogger().Infof("TSC Freqency node update status: %d updated, %d skipped, %d errors", stats.updated, stats.skipped, stats.error)
-	}, interval, 1.2, true, stopChan)
+		nodeLabelsUpdatedTotal.WithLabelValues(node.Name).Inc()
+		if n.eventRecorder != nil {
+			n.eventRecorder.Eventf(node, v1.EventTypeNormal, "TSCLabelsUpdated", "Removed stale TSC frequency labels, node no longer advertises an invariant TSC: %v", staleLabels)
+		}
+	}
 }
 
-func patchNode(client kubecli.KubevirtClient, original *v1.Node, modified *v1.Node) error {
+func patchNode(client kubecli.KubevirtClient, original *v1.Node, modified *v1.Node, mode PatchMode) error {
+	if mode == ServerSideApply {
+		return applyNode(client, modified)
+	}
+
 	originalBytes, err := json.Marshal(original)
 	if err != nil {
 		return fmt.Errorf("could not serialize original object: %v", err)
@@ -83,11 +360,60 @@ func patchNode(client kubecli.KubevirtClient, original *v1.Node, modified *v1.No
 	return nil
 }
 
-func calculateNodeLabelChanges(original *v1.Node, requiredFrequencies []int64) (modified *v1.Node, err error) {
+// nodeApplyConfiguration is a hand-rolled, minimal stand-in for
+// corev1apply.NodeApplyConfiguration. The generated apply configuration
+// tags Labels with `json:"labels,omitempty"`, and encoding/json omits a map
+// field on omitempty whenever its length is zero, nil or not. That would
+// silently drop "labels" from the request body the moment this controller's
+// managed set goes to zero, so the apiserver would see no opinion on
+// metadata.labels from this field manager and prune nothing. Marshaling our
+// own type without the omitempty tag keeps an explicit "labels":{} in the
+// body, which is what actually tells Server-Side Apply "I now own zero of
+// these labels."
+type nodeApplyConfiguration struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name   string            `json:"name"`
+		Labels map[string]string `json:"labels"`
+	} `json:"metadata"`
+}
+
+// applyNode declares this controller the field owner of exactly the TSC
+// frequency labels it manages, via Server-Side Apply. Labels it no longer
+// requires are pruned automatically since they are simply left out of the
+// applied configuration; conflicts with other field managers surface as
+// apierrors.IsConflict errors.
+func applyNode(client kubecli.KubevirtClient, modified *v1.Node) error {
+	managedLabels := map[string]string{}
+	for key, value := range modified.Labels {
+		if strings.HasPrefix(key, tscFrequencyLabelPrefix) {
+			managedLabels[key] = value
+		}
+	}
+
+	cfg := nodeApplyConfiguration{APIVersion: "v1", Kind: "Node"}
+	cfg.Metadata.Name = modified.Name
+	cfg.Metadata.Labels = managedLabels
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("could not build apply configuration: %v", err)
+	}
+
+	force := true
+	patchOptions := v12.PatchOptions{FieldManager: fieldManager, Force: &force}
+	if _, err := client.CoreV1().Nodes().Patch(context.Background(), modified.Name, types.ApplyPatchType, data, patchOptions); err != nil {
+		return fmt.Errorf("could not apply TSC frequency labels: %v", err)
+	}
+	return nil
+}
+
+func calculateNodeLabelChanges(original *v1.Node, requiredFrequencies []int64) (modified *v1.Node, added []string, removed []string, err error) {
 	nodeFreq, scalable, err := TSCFrequencyFromNode(original)
 	if err != nil {
 		log.DefaultLogger().Reason(err).Object(original).Error("Can't determine TSC frequency of the original")
-		return nil, err
+		return nil, nil, nil, err
 	}
 	freqsOnNode := TSCFrequenciesOnNode(original)
 	toAdd, toRemove := CalculateTSCLabelDiff(requiredFrequencies, freqsOnNode, nodeFreq, scalable)
@@ -101,7 +427,7 @@ func calculateNodeLabelChanges(original *v1.Node, requiredFrequencies []int64) (
 	for _, freq := range toRemoveLabels {
 		delete(nodeCopy.Labels, freq)
 	}
-	return nodeCopy, nil
+	return nodeCopy, toAddLabels, toRemoveLabels, nil
 }
 
 func (n nodeTopologyUpdater) requiredFrequencies() []int64 {
@@ -109,13 +435,26 @@ func (n nodeTopologyUpdater) requiredFrequencies() []int64 {
 	if err != nil {
 		log.DefaultLogger().Reason(err).Error("Failed to calculate lowest TSC frequency for nodes")
 	}
-	return append(n.hinter.TSCFrequenciesInUse(), lowestFrequency)
+	required := append(n.hinter.TSCFrequenciesInUse(), lowestFrequency)
+	distinct := map[int64]struct{}{}
+	for _, freq := range required {
+		distinct[freq] = struct{}{}
+	}
+	requiredFrequenciesCount.Set(float64(len(distinct)))
+	return required
 }
 
-func NewNodeTopologyUpdater(clientset kubecli.KubevirtClient, hinter Hinter, nodeStore cache.Store) NodeTopologyUpdater {
+func NewNodeTopologyUpdater(clientset kubecli.KubevirtClient, hinter Hinter, nodeInformer cache.SharedIndexInformer, eventRecorder record.EventRecorder, patchMode PatchMode, leaderElectionConfig LeaderElectionConfig) NodeTopologyUpdater {
 	return &nodeTopologyUpdater{
-		client:    clientset,
-		hinter:    hinter,
-		nodeStore: nodeStore,
+		client:               clientset,
+		hinter:               hinter,
+		nodeInformer:         nodeInformer,
+		nodeStore:            nodeInformer.GetStore(),
+		queue:                workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "tsc-node-topology-updater"),
+		workers:              defaultWorkerCount,
+		eventRecorder:        eventRecorder,
+		patchMode:            patchMode,
+		leaderElectionConfig: leaderElectionConfig,
+		identity:             string(uuid.NewUUID()),
 	}
 }
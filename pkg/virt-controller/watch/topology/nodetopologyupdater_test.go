@@ -0,0 +1,119 @@
+package topology
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"go.uber.org/mock/gomock"
+
+	"kubevirt.io/client-go/kubecli"
+)
+
+type fakeHinter struct {
+	lowest int64
+	inUse  []int64
+}
+
+func (f *fakeHinter) LowestTSCFrequencyOnCluster() (int64, error) {
+	return f.lowest, nil
+}
+
+func (f *fakeHinter) TSCFrequenciesInUse() []int64 {
+	return f.inUse
+}
+
+func newTestClient(ctrl *gomock.Controller, clientset *fake.Clientset) kubecli.KubevirtClient {
+	client := kubecli.NewMockKubevirtClient(ctrl)
+	client.EXPECT().CoreV1().Return(clientset.CoreV1()).AnyTimes()
+	client.EXPECT().CoordinationV1().Return(clientset.CoordinationV1()).AnyTimes()
+	return client
+}
+
+func countNodePatchActions(clientset *fake.Clientset) int {
+	count := 0
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "patch" && action.GetResource().Resource == "nodes" {
+			count++
+		}
+	}
+	return count
+}
+
+var _ = Describe("NodeTopologyUpdater leader election", func() {
+	It("only allows a single instance among several replicas to patch nodes at a time", func() {
+		ctrl := gomock.NewController(GinkgoT())
+		defer ctrl.Finish()
+
+		clientset := fake.NewSimpleClientset()
+		// A frequency mismatch against the node's existing annotation
+		// guarantees reconcileNode computes a real label diff and attempts
+		// a patch, rather than silently skipping.
+		hinter := &fakeHinter{lowest: 2000, inUse: []int64{}}
+
+		node := &v1.Node{
+			ObjectMeta: v12.ObjectMeta{
+				Name: "node01",
+				Annotations: map[string]string{
+					"kubevirt.io/tsc-frequency": "1000",
+				},
+			},
+		}
+		_, err := clientset.CoreV1().Nodes().Create(context.TODO(), node, v12.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		const replicas = 3
+
+		leConfig := LeaderElectionConfig{
+			LeaseName:      "tsc-node-topology-updater",
+			LeaseNamespace: "kubevirt",
+			LeaseDuration:  2 * time.Second,
+			RenewDeadline:  1 * time.Second,
+			RetryPeriod:    200 * time.Millisecond,
+		}
+
+		stopChans := make([]chan struct{}, replicas)
+		for i := 0; i < replicas; i++ {
+			stopChans[i] = make(chan struct{})
+			nodeInformer := informers.NewSharedInformerFactory(clientset, 0).Core().V1().Nodes().Informer()
+			Expect(nodeInformer.GetStore().Add(node.DeepCopy())).To(Succeed())
+
+			updater := NewNodeTopologyUpdater(newTestClient(ctrl, clientset), hinter, nodeInformer, nil, StrategicMerge, leConfig)
+			casted := updater.(*nodeTopologyUpdater)
+			casted.identity = fmt.Sprintf("replica-%d", i)
+
+			// A long interval means only the leader's immediate, initial
+			// full resync patches the node during this test; it rules out
+			// a second tick double-patching the (locally stale) cached node
+			// and masking a non-leader also having reconciled once.
+			go casted.Run(10*time.Second, stopChans[i])
+		}
+
+		Eventually(func() int {
+			return countNodePatchActions(clientset)
+		}, 2*time.Second, 20*time.Millisecond).Should(BeNumerically(">=", 1))
+
+		// Give the non-leader replicas a fair chance to (incorrectly) patch
+		// too before asserting only one reconciliation ever took place.
+		time.Sleep(300 * time.Millisecond)
+
+		Expect(countNodePatchActions(clientset)).To(Equal(1), "only the elected leader should have patched the node")
+
+		for _, stopChan := range stopChans {
+			close(stopChan)
+		}
+
+		leases, err := clientset.CoordinationV1().Leases(leConfig.LeaseNamespace).List(context.TODO(), v12.ListOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(leases.Items).To(HaveLen(1))
+		Expect(*leases.Items[0].Spec.HolderIdentity).ToNot(BeEmpty())
+	})
+})
@@ -0,0 +1,86 @@
+package topology
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"go.uber.org/mock/gomock"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("patchNode", func() {
+	var clientset *fake.Clientset
+	var node *v1.Node
+
+	BeforeEach(func() {
+		node = &v1.Node{
+			ObjectMeta: v12.ObjectMeta{
+				Name: "node01",
+				Labels: map[string]string{
+					"scheduling.node.kubevirt.io/tsc-frequency-3000": "true",
+					"some-other-owners-label":                        "true",
+				},
+			},
+		}
+		clientset = fake.NewSimpleClientset(node.DeepCopy())
+	})
+
+	It("sends a strategic merge patch in StrategicMerge mode", func() {
+		ctrl := gomock.NewController(GinkgoT())
+		defer ctrl.Finish()
+
+		modified := node.DeepCopy()
+		delete(modified.Labels, "scheduling.node.kubevirt.io/tsc-frequency-3000")
+		modified.Labels["scheduling.node.kubevirt.io/tsc-frequency-2000"] = "true"
+
+		Expect(patchNode(newTestClient(ctrl, clientset), node, modified, StrategicMerge)).To(Succeed())
+
+		updated, err := clientset.CoreV1().Nodes().Get(context.TODO(), "node01", v12.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(updated.Labels).To(HaveKeyWithValue("scheduling.node.kubevirt.io/tsc-frequency-2000", "true"))
+		Expect(updated.Labels).ToNot(HaveKey("scheduling.node.kubevirt.io/tsc-frequency-3000"))
+		Expect(updated.Labels).To(HaveKey("some-other-owners-label"))
+	})
+
+	It("only applies the managed tsc-frequency labels in ServerSideApply mode", func() {
+		ctrl := gomock.NewController(GinkgoT())
+		defer ctrl.Finish()
+
+		modified := node.DeepCopy()
+		delete(modified.Labels, "scheduling.node.kubevirt.io/tsc-frequency-3000")
+		modified.Labels["scheduling.node.kubevirt.io/tsc-frequency-2000"] = "true"
+
+		Expect(patchNode(newTestClient(ctrl, clientset), node, modified, ServerSideApply)).To(Succeed())
+
+		updated, err := clientset.CoreV1().Nodes().Get(context.TODO(), "node01", v12.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(updated.Labels).To(HaveKeyWithValue("scheduling.node.kubevirt.io/tsc-frequency-2000", "true"))
+		Expect(updated.Labels).To(HaveKey("some-other-owners-label"))
+	})
+
+	It("prunes the last managed tsc-frequency label when the managed set goes to zero in ServerSideApply mode", func() {
+		ctrl := gomock.NewController(GinkgoT())
+		defer ctrl.Finish()
+
+		// First apply establishes this field manager's ownership of
+		// tsc-frequency-3000, mirroring how the controller would have
+		// created the label in an earlier reconcile.
+		Expect(patchNode(newTestClient(ctrl, clientset), node, node, ServerSideApply)).To(Succeed())
+
+		modified := node.DeepCopy()
+		delete(modified.Labels, "scheduling.node.kubevirt.io/tsc-frequency-3000")
+
+		Expect(patchNode(newTestClient(ctrl, clientset), node, modified, ServerSideApply)).To(Succeed())
+
+		updated, err := clientset.CoreV1().Nodes().Get(context.TODO(), "node01", v12.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(updated.Labels).ToNot(HaveKey("scheduling.node.kubevirt.io/tsc-frequency-3000"))
+		Expect(updated.Labels).To(HaveKey("some-other-owners-label"))
+	})
+})
@@ -0,0 +1,109 @@
+package topology
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	v1 "k8s.io/api/core/v1"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"go.uber.org/mock/gomock"
+
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	ktesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+var _ = Describe("nodeTopologyUpdater reconcileNode instrumentation", func() {
+	var clientset *fake.Clientset
+	var informer cache.SharedIndexInformer
+	var updater *nodeTopologyUpdater
+	var recorder *record.FakeRecorder
+
+	newNode := func() *v1.Node {
+		return &v1.Node{
+			ObjectMeta: v12.ObjectMeta{
+				Name: "node01",
+				Annotations: map[string]string{
+					"kubevirt.io/tsc-frequency": "1000",
+				},
+			},
+		}
+	}
+
+	BeforeEach(func() {
+		ctrl := gomock.NewController(GinkgoT())
+		DeferCleanup(ctrl.Finish)
+
+		clientset = fake.NewSimpleClientset()
+		informer = informers.NewSharedInformerFactory(clientset, 0).Core().V1().Nodes().Informer()
+		recorder = record.NewFakeRecorder(10)
+		updater = &nodeTopologyUpdater{
+			client:        newTestClient(ctrl, clientset),
+			nodeInformer:  informer,
+			nodeStore:     informer.GetStore(),
+			eventRecorder: recorder,
+			patchMode:     StrategicMerge,
+		}
+	})
+
+	It("increments nodeLabelsUpdatedTotal and emits a TSCLabelsUpdated event when labels change", func() {
+		updater.hinter = &fakeHinter{lowest: 2000, inUse: []int64{}}
+
+		node := newNode()
+		Expect(informer.GetStore().Add(node)).To(Succeed())
+		_, err := clientset.CoreV1().Nodes().Create(context.TODO(), node, v12.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		before := testutil.ToFloat64(nodeLabelsUpdatedTotal.WithLabelValues("node01"))
+
+		Expect(updater.reconcileNode("node01")).To(Succeed())
+
+		Expect(testutil.ToFloat64(nodeLabelsUpdatedTotal.WithLabelValues("node01"))).To(Equal(before + 1))
+		Expect(recorder.Events).To(Receive(ContainSubstring("TSCLabelsUpdated")))
+	})
+
+	It("increments nodeLabelsSkippedTotal and emits no event when labels are already up to date", func() {
+		updater.hinter = &fakeHinter{lowest: 1000, inUse: []int64{}}
+
+		node := newNode()
+		Expect(informer.GetStore().Add(node)).To(Succeed())
+		_, err := clientset.CoreV1().Nodes().Create(context.TODO(), node, v12.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		before := testutil.ToFloat64(nodeLabelsSkippedTotal.WithLabelValues("node01"))
+
+		Expect(updater.reconcileNode("node01")).To(Succeed())
+
+		Expect(testutil.ToFloat64(nodeLabelsSkippedTotal.WithLabelValues("node01"))).To(Equal(before + 1))
+		Expect(recorder.Events).ToNot(Receive())
+	})
+
+	It("increments nodeLabelsErrorsTotal with reason patch_failed and emits a TSCLabelPatchFailed event when the patch fails", func() {
+		updater.hinter = &fakeHinter{lowest: 2000, inUse: []int64{}}
+
+		node := newNode()
+		Expect(informer.GetStore().Add(node)).To(Succeed())
+		_, err := clientset.CoreV1().Nodes().Create(context.TODO(), node, v12.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		clientset.PrependReactor("patch", "nodes", func(action ktesting.Action) (bool, runtime.Object, error) {
+			return true, nil, fmt.Errorf("simulated patch failure")
+		})
+
+		before := testutil.ToFloat64(nodeLabelsErrorsTotal.WithLabelValues("node01", "patch_failed"))
+
+		Expect(updater.reconcileNode("node01")).To(HaveOccurred())
+
+		Expect(testutil.ToFloat64(nodeLabelsErrorsTotal.WithLabelValues("node01", "patch_failed"))).To(Equal(before + 1))
+		Expect(recorder.Events).To(Receive(ContainSubstring("TSCLabelPatchFailed")))
+	})
+})
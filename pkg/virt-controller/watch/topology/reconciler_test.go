@@ -0,0 +1,51 @@
+package topology
+
+import (
+	v1 "k8s.io/api/core/v1"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+var _ = Describe("nodeTopologyUpdater workqueue plumbing", func() {
+	var informer cache.SharedIndexInformer
+	var updater *nodeTopologyUpdater
+
+	BeforeEach(func() {
+		clientset := fake.NewSimpleClientset()
+		informer = informers.NewSharedInformerFactory(clientset, 0).Core().V1().Nodes().Informer()
+		updater = &nodeTopologyUpdater{
+			hinter:       &fakeHinter{lowest: 1000, inUse: []int64{2000}},
+			nodeInformer: informer,
+			nodeStore:    informer.GetStore(),
+			queue:        workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+			workers:      1,
+		}
+	})
+
+	It("enqueues a node key on Requeue", func() {
+		updater.Requeue("node01")
+		key, shutdown := updater.queue.Get()
+		Expect(shutdown).To(BeFalse())
+		Expect(key).To(Equal("node01"))
+	})
+
+	It("enqueues every node in the store on a full resync", func() {
+		Expect(informer.GetStore().Add(&v1.Node{ObjectMeta: v12.ObjectMeta{Name: "node01"}})).To(Succeed())
+		Expect(informer.GetStore().Add(&v1.Node{ObjectMeta: v12.ObjectMeta{Name: "node02"}})).To(Succeed())
+
+		updater.enqueueAllNodes()
+
+		Expect(updater.queue.Len()).To(Equal(2))
+	})
+
+	It("is a no-op when reconciling a key that no longer exists in the store", func() {
+		Expect(updater.reconcileNode("missing-node")).To(Succeed())
+	})
+})